@@ -0,0 +1,136 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+// CurveID is the type of a TLS identifier for an elliptic curve. See
+// http://www.iana.org/assignments/tls-parameters/tls-parameters.xml#tls-parameters-8
+type CurveID uint16
+
+const (
+	CurveP256 CurveID = 23
+	CurveP384 CurveID = 24
+	CurveP521 CurveID = 25
+	X25519    CurveID = 29
+
+	// X25519Kyber768Draft00 is the hybrid X25519/Kyber-768 key exchange
+	// group from draft-tls-westerbaan-xyber768d00, as deployed by Chrome
+	// and BoringSSL ahead of standardization.
+	X25519Kyber768Draft00 CurveID = 0x6399
+	// X25519MLKEM768 is the hybrid X25519/ML-KEM-768 key exchange group
+	// from draft-kwiatkowski-tls-ecdhe-mlkem, the standardized successor
+	// to X25519Kyber768Draft00.
+	X25519MLKEM768 CurveID = 0x11ec
+)
+
+// TLS extension numbers
+const (
+	extensionServerName          uint16 = 0
+	extensionStatusRequest       uint16 = 5
+	extensionSupportedCurves     uint16 = 10
+	extensionSupportedPoints     uint16 = 11
+	extensionSignatureAlgorithms uint16 = 13
+	extensionALPN                uint16 = 16
+	extensionSCT                 uint16 = 18
+	extensionSessionTicket       uint16 = 35
+	extensionPreSharedKey        uint16 = 41
+	extensionEarlyData           uint16 = 42
+	extensionSupportedVersions   uint16 = 43
+	extensionCookie              uint16 = 44
+	extensionPSKModes            uint16 = 45
+	extensionKeyShare            uint16 = 51
+	extensionNextProtoNeg        uint16 = 13172
+	extensionRenegotiationInfo   uint16 = 0xff01
+)
+
+// keyShare is the tls 1.3 KeyShareEntry struct, as used in the
+// "key_share" extension of both ClientHello and ServerHello.
+type keyShare struct {
+	group CurveID
+	data  []byte
+}
+
+// signatureAndHash mirrors the TLS 1.2, SignatureAndHashAlgorithm struct. See
+// RFC 5246, section A.4.1.
+type signatureAndHash struct {
+	hash, signature uint8
+}
+
+// supportedSKXSignatureAlgorithms contains the signature and hash algorithms
+// that the code advertises as supported in a TLS 1.2 ClientHello.
+var supportedSKXSignatureAlgorithms = []signatureAndHash{
+	{hashSHA256, signatureRSA},
+	{hashSHA256, signatureECDSA},
+	{hashSHA384, signatureRSA},
+	{hashSHA384, signatureECDSA},
+	{hashSHA1, signatureRSA},
+	{hashSHA1, signatureECDSA},
+}
+
+// Hash functions for signatureAndHash, as defined in RFC 5246, section 7.4.1.4.1.
+const (
+	hashSHA1   uint8 = 2
+	hashSHA256 uint8 = 4
+	hashSHA384 uint8 = 5
+)
+
+// Signature algorithms for signatureAndHash, as defined in RFC 5246, section 7.4.1.4.1.
+const (
+	signatureRSA   uint8 = 1
+	signatureECDSA uint8 = 3
+)
+
+// Certificate status types, as defined in RFC 3546, section 3.3.
+const (
+	statusTypeOCSP uint8 = 1
+)
+
+// TLS handshake message types.
+const (
+	typeHelloRequest        uint8 = 0
+	typeClientHello         uint8 = 1
+	typeServerHello         uint8 = 2
+	typeNewSessionTicket    uint8 = 4
+	typeEndOfEarlyData      uint8 = 5
+	typeEncryptedExtensions uint8 = 8
+	typeCertificate         uint8 = 11
+	typeServerKeyExchange   uint8 = 12
+	typeCertificateRequest  uint8 = 13
+	typeServerHelloDone     uint8 = 14
+	typeCertificateVerify   uint8 = 15
+	typeClientKeyExchange   uint8 = 16
+	typeFinished            uint8 = 20
+	typeCertificateStatus   uint8 = 22
+	typeNextProtocol        uint8 = 67 // Not IANA assigned
+)
+
+// greaseValues enumerates the reserved GREASE (Generate Random Extensions
+// And Sustain Extensibility) code points from RFC 8701. Each value has
+// identical upper and lower bytes, which is how peers are meant to
+// recognize and ignore them.
+var greaseValues = [16]uint16{
+	0x0a0a, 0x1a1a, 0x2a2a, 0x3a3a,
+	0x4a4a, 0x5a5a, 0x6a6a, 0x7a7a,
+	0x8a8a, 0x9a9a, 0xaaaa, 0xbaba,
+	0xcaca, 0xdada, 0xeaea, 0xfafa,
+}
+
+// isGREASEValue reports whether v is one of the reserved RFC 8701 GREASE
+// code points.
+func isGREASEValue(v uint16) bool {
+	hi, lo := uint8(v>>8), uint8(v)
+	return hi == lo && lo&0x0f == 0x0a
+}
+
+// greaseValueFor derives the GREASE code point to use for the given
+// purpose (an arbitrary per-field index), deterministically from the
+// ClientHello random so that repeated probes against the same target
+// produce byte-identical output.
+func greaseValueFor(random []byte, purpose int) uint16 {
+	if len(random) == 0 {
+		return greaseValues[purpose%len(greaseValues)]
+	}
+	b := int(random[purpose%len(random)])
+	return greaseValues[(b+purpose)%len(greaseValues)]
+}
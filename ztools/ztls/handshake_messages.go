@@ -0,0 +1,2346 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	"bytes"
+)
+
+type clientHelloMsg struct {
+	raw                []byte
+	vers               uint16
+	random             []byte
+	sessionId          []byte
+	cipherSuites       []uint16
+	compressionMethods []uint8
+	nextProtoNeg       bool
+	serverName         string
+	ocspStapling       bool
+	supportedCurves    []CurveID
+	supportedPoints    []uint8
+	ticketSupported    bool
+	sessionTicket      []uint8
+	signatureAndHashes []signatureAndHash
+	alpnProtocols      []string
+
+	// TLS 1.3
+	keyShares           []keyShare
+	supportedVersions   []uint16
+	pskKeyExchangeModes []uint8
+	pskIdentities       []pskIdentity
+	pskBinders          [][]byte
+
+	// grease, if set, salts the marshaled ClientHello with RFC 8701 GREASE
+	// values and relaxes unmarshal's key_share/supported_groups check.
+	grease              bool
+	greaseExtension     uint16
+	greaseExtensionData []byte
+}
+
+// pskIdentity is the PskIdentity struct from the pre_shared_key extension,
+// as defined in https://tools.ietf.org/html/rfc8446#section-4.2.11.
+type pskIdentity struct {
+	label               []byte
+	obfuscatedTicketAge uint32
+}
+
+func (m *clientHelloMsg) equal(i interface{}) bool {
+	m1, ok := i.(*clientHelloMsg)
+	if !ok {
+		return false
+	}
+
+	return bytes.Equal(m.raw, m1.raw) &&
+		m.vers == m1.vers &&
+		bytes.Equal(m.random, m1.random) &&
+		bytes.Equal(m.sessionId, m1.sessionId) &&
+		eqUint16s(m.cipherSuites, m1.cipherSuites) &&
+		bytes.Equal(m.compressionMethods, m1.compressionMethods) &&
+		m.nextProtoNeg == m1.nextProtoNeg &&
+		m.serverName == m1.serverName &&
+		m.ocspStapling == m1.ocspStapling &&
+		eqCurveIDs(m.supportedCurves, m1.supportedCurves) &&
+		bytes.Equal(m.supportedPoints, m1.supportedPoints) &&
+		m.ticketSupported == m1.ticketSupported &&
+		bytes.Equal(m.sessionTicket, m1.sessionTicket) &&
+		eqSignatureAndHashes(m.signatureAndHashes, m1.signatureAndHashes) &&
+		eqStrings(m.alpnProtocols, m1.alpnProtocols) &&
+		eqKeyShares(m.keyShares, m1.keyShares) &&
+		eqUint16s(m.supportedVersions, m1.supportedVersions) &&
+		bytes.Equal(m.pskKeyExchangeModes, m1.pskKeyExchangeModes) &&
+		eqPSKIdentities(m.pskIdentities, m1.pskIdentities) &&
+		eqByteSlices(m.pskBinders, m1.pskBinders) &&
+		m.greaseExtension == m1.greaseExtension &&
+		bytes.Equal(m.greaseExtensionData, m1.greaseExtensionData)
+}
+
+// applyGREASE salts the ClientHello with RFC 8701 GREASE values in
+// cipherSuites, supportedVersions, supportedCurves, signatureAndHashes,
+// alpnProtocols, keyShares and one extra unknown extension. The values are
+// derived deterministically from the ClientHello random. It is called once
+// from marshal when m.grease is set.
+func (m *clientHelloMsg) applyGREASE() {
+	m.cipherSuites = append(m.cipherSuites, greaseValueFor(m.random, 0))
+	m.supportedVersions = append(m.supportedVersions, greaseValueFor(m.random, 1))
+	m.supportedCurves = append(m.supportedCurves, CurveID(greaseValueFor(m.random, 2)))
+
+	g := greaseValueFor(m.random, 3)
+	m.signatureAndHashes = append(m.signatureAndHashes, signatureAndHash{hash: uint8(g >> 8), signature: uint8(g)})
+
+	g = greaseValueFor(m.random, 4)
+	m.alpnProtocols = append(m.alpnProtocols, string([]byte{uint8(g >> 8), uint8(g)}))
+
+	payload := []byte{0}
+	if len(m.random) > 5 && m.random[5]&1 == 1 {
+		payload = append(payload, 0)
+	}
+	m.keyShares = append(m.keyShares, keyShare{group: CurveID(greaseValueFor(m.random, 5)), data: payload})
+
+	m.greaseExtension = greaseValueFor(m.random, 6)
+	m.greaseExtensionData = payload
+}
+
+func (m *clientHelloMsg) marshal() []byte {
+	if m.raw != nil {
+		return m.raw
+	}
+	if m.grease {
+		m.applyGREASE()
+	}
+
+	length := 2 + 32 + 1 + len(m.sessionId) + 2 + len(m.cipherSuites)*2 + 1 + len(m.compressionMethods)
+
+	numExtensions := 0
+	extensionsLength := 0
+	if m.nextProtoNeg {
+		numExtensions++
+	}
+	if m.ocspStapling {
+		extensionsLength += 1 + 2 + 2
+		numExtensions++
+	}
+	if len(m.serverName) > 0 {
+		extensionsLength += 5 + len(m.serverName)
+		numExtensions++
+	}
+	if len(m.supportedCurves) > 0 {
+		extensionsLength += 2 + 2*len(m.supportedCurves)
+		numExtensions++
+	}
+	if len(m.supportedPoints) > 0 {
+		extensionsLength += 1 + len(m.supportedPoints)
+		numExtensions++
+	}
+	if m.ticketSupported {
+		extensionsLength += len(m.sessionTicket)
+		numExtensions++
+	}
+	if len(m.signatureAndHashes) > 0 {
+		extensionsLength += 2 + 2*len(m.signatureAndHashes)
+		numExtensions++
+	}
+	if len(m.alpnProtocols) > 0 {
+		extensionsLength += 2
+		for _, s := range m.alpnProtocols {
+			if l := len(s); l == 0 || l > 255 {
+				panic("invalid ALPN protocol")
+			}
+			extensionsLength++
+			extensionsLength += len(s)
+		}
+		numExtensions++
+	}
+	if len(m.keyShares) > 0 {
+		extensionsLength += 2
+		for _, ks := range m.keyShares {
+			extensionsLength += 4 + len(ks.data)
+		}
+		numExtensions++
+	}
+	if len(m.supportedVersions) > 0 {
+		extensionsLength += 1 + 2*len(m.supportedVersions)
+		numExtensions++
+	}
+	if m.greaseExtension != 0 {
+		extensionsLength += len(m.greaseExtensionData)
+		numExtensions++
+	}
+	if len(m.pskKeyExchangeModes) > 0 {
+		extensionsLength += 1 + len(m.pskKeyExchangeModes)
+		numExtensions++
+	}
+	pskExtensionLength := 0
+	if len(m.pskIdentities) > 0 {
+		identitiesLength := 0
+		for _, psk := range m.pskIdentities {
+			identitiesLength += 2 + len(psk.label) + 4
+		}
+		bindersLength := 0
+		for _, binder := range m.pskBinders {
+			bindersLength += 1 + len(binder)
+		}
+		pskExtensionLength = 2 + identitiesLength + 2 + bindersLength
+		extensionsLength += pskExtensionLength
+		numExtensions++
+	}
+	if numExtensions > 0 {
+		extensionsLength += 4 * numExtensions
+		length += 2 + extensionsLength
+	}
+
+	x := make([]byte, 4+length)
+	x[0] = typeClientHello
+	x[1] = uint8(length >> 16)
+	x[2] = uint8(length >> 8)
+	x[3] = uint8(length)
+	x[4] = uint8(m.vers >> 8)
+	x[5] = uint8(m.vers)
+	copy(x[6:38], m.random)
+	x[38] = uint8(len(m.sessionId))
+	copy(x[39:39+len(m.sessionId)], m.sessionId)
+	y := x[39+len(m.sessionId):]
+	y[0] = uint8(len(m.cipherSuites) >> 7)
+	y[1] = uint8(len(m.cipherSuites) << 1)
+	for i, suite := range m.cipherSuites {
+		y[2+i*2] = uint8(suite >> 8)
+		y[3+i*2] = uint8(suite)
+	}
+	z := y[2+len(m.cipherSuites)*2:]
+	z[0] = uint8(len(m.compressionMethods))
+	copy(z[1:], m.compressionMethods)
+
+	z = z[1+len(m.compressionMethods):]
+	if numExtensions > 0 {
+		z[0] = uint8(extensionsLength >> 8)
+		z[1] = uint8(extensionsLength)
+		z = z[2:]
+	}
+	if m.nextProtoNeg {
+		ext := extensionNextProtoNeg
+		z[0] = uint8(ext >> 8)
+		z[1] = uint8(ext)
+		// The length is always 0 here.
+		z = z[4:]
+	}
+	if len(m.serverName) > 0 {
+		z[0] = uint8(extensionServerName >> 8)
+		z[1] = uint8(extensionServerName)
+		l := len(m.serverName) + 5
+		z[2] = uint8(l >> 8)
+		z[3] = uint8(l)
+		z = z[4:]
+
+		// RFC 3546, section 3.1
+		//
+		// struct {
+		//     NameType name_type;
+		//     select (name_type) {
+		//         case host_name: HostName;
+		//     } name;
+		// } ServerName;
+		//
+		// enum {
+		//     host_name(0), (255)
+		// } NameType;
+		//
+		// opaque HostName<1..2^16-1>;
+		//
+		// struct {
+		//     ServerName server_name_list<1..2^16-1>
+		// } ServerNameList;
+
+		z[0] = uint8((len(m.serverName) + 3) >> 8)
+		z[1] = uint8(len(m.serverName) + 3)
+		z[3] = uint8(len(m.serverName) >> 8)
+		z[4] = uint8(len(m.serverName))
+		copy(z[5:], []byte(m.serverName))
+		z = z[5+len(m.serverName):]
+	}
+	if m.ocspStapling {
+		// RFC 4366, section 3.6
+		z[0] = uint8(extensionStatusRequest >> 8)
+		z[1] = uint8(extensionStatusRequest)
+		z[2] = 0
+		z[3] = 5
+		z[4] = 1 // OCSP type
+		// Two zero valued uint16s for the two lengths.
+		z = z[9:]
+	}
+	if len(m.supportedCurves) > 0 {
+		// http://tools.ietf.org/html/rfc4492#section-5.5.1
+		z[0] = uint8(extensionSupportedCurves >> 8)
+		z[1] = uint8(extensionSupportedCurves)
+		l := 2 + 2*len(m.supportedCurves)
+		z[2] = uint8(l >> 8)
+		z[3] = uint8(l)
+		l -= 2
+		z[4] = uint8(l >> 8)
+		z[5] = uint8(l)
+		z = z[6:]
+		for _, curve := range m.supportedCurves {
+			z[0] = uint8(curve >> 8)
+			z[1] = uint8(curve)
+			z = z[2:]
+		}
+	}
+	if len(m.supportedPoints) > 0 {
+		// http://tools.ietf.org/html/rfc4492#section-5.5.2
+		z[0] = uint8(extensionSupportedPoints >> 8)
+		z[1] = uint8(extensionSupportedPoints)
+		l := 1 + len(m.supportedPoints)
+		z[2] = uint8(l >> 8)
+		z[3] = uint8(l)
+		l--
+		z[4] = uint8(l)
+		z = z[5:]
+		copy(z, m.supportedPoints)
+		z = z[len(m.supportedPoints):]
+	}
+	if m.ticketSupported {
+		// http://tools.ietf.org/html/rfc5077#section-3.2
+		z[0] = uint8(extensionSessionTicket >> 8)
+		z[1] = uint8(extensionSessionTicket)
+		l := len(m.sessionTicket)
+		z[2] = uint8(l >> 8)
+		z[3] = uint8(l)
+		z = z[4:]
+		copy(z, m.sessionTicket)
+		z = z[len(m.sessionTicket):]
+	}
+	if len(m.signatureAndHashes) > 0 {
+		// https://tools.ietf.org/html/rfc5246#section-7.4.1.4.1
+		z[0] = uint8(extensionSignatureAlgorithms >> 8)
+		z[1] = uint8(extensionSignatureAlgorithms)
+		l := 2 + 2*len(m.signatureAndHashes)
+		z[2] = uint8(l >> 8)
+		z[3] = uint8(l)
+		l -= 2
+		z[4] = uint8(l >> 8)
+		z[5] = uint8(l)
+		z = z[6:]
+		for _, sigAndHash := range m.signatureAndHashes {
+			z[0] = sigAndHash.hash
+			z[1] = sigAndHash.signature
+			z = z[2:]
+		}
+	}
+	if len(m.alpnProtocols) > 0 {
+		z[0] = uint8(extensionALPN >> 8)
+		z[1] = uint8(extensionALPN)
+		lengths := z[2:]
+		z = z[6:]
+
+		stringsLength := 0
+		for _, s := range m.alpnProtocols {
+			l := len(s)
+			z[0] = byte(l)
+			copy(z[1:], s)
+			z = z[1+l:]
+			stringsLength += 1 + l
+		}
+
+		lengths[2] = byte(stringsLength >> 8)
+		lengths[3] = byte(stringsLength)
+		stringsLength += 2
+		lengths[0] = byte(stringsLength >> 8)
+		lengths[1] = byte(stringsLength)
+	}
+	if len(m.keyShares) > 0 {
+		// https://tools.ietf.org/html/draft-ietf-tls-tls13, section 6.3.2.3
+		z[0] = uint8(extensionKeyShare >> 8)
+		z[1] = uint8(extensionKeyShare)
+		lengths := z[2:]
+		z = z[6:]
+
+		keyShareLength := 0
+		for _, ks := range m.keyShares {
+			z[0] = uint8(ks.group >> 8)
+			z[1] = uint8(ks.group)
+			l := len(ks.data)
+			z[2] = uint8(l >> 8)
+			z[3] = uint8(l)
+			copy(z[4:], ks.data)
+			z = z[4+l:]
+			keyShareLength += 4 + l
+		}
+
+		lengths[2] = byte(keyShareLength >> 8)
+		lengths[3] = byte(keyShareLength)
+		keyShareLength += 2
+		lengths[0] = byte(keyShareLength >> 8)
+		lengths[1] = byte(keyShareLength)
+	}
+	if len(m.supportedVersions) > 0 {
+		z[0] = uint8(extensionSupportedVersions >> 8)
+		z[1] = uint8(extensionSupportedVersions)
+		l := 1 + 2*len(m.supportedVersions)
+		z[2] = uint8(l >> 8)
+		z[3] = uint8(l)
+		l--
+		z[4] = uint8(l)
+		z = z[5:]
+		for _, vers := range m.supportedVersions {
+			z[0] = uint8(vers >> 8)
+			z[1] = uint8(vers)
+			z = z[2:]
+		}
+	}
+	if m.greaseExtension != 0 {
+		// A single unknown extension, used to probe server tolerance of
+		// unrecognized ClientHello extensions. See RFC 8701.
+		z[0] = uint8(m.greaseExtension >> 8)
+		z[1] = uint8(m.greaseExtension)
+		l := len(m.greaseExtensionData)
+		z[2] = uint8(l >> 8)
+		z[3] = uint8(l)
+		copy(z[4:], m.greaseExtensionData)
+		z = z[4+l:]
+	}
+	if len(m.pskKeyExchangeModes) > 0 {
+		z[0] = uint8(extensionPSKModes >> 8)
+		z[1] = uint8(extensionPSKModes)
+		l := 1 + len(m.pskKeyExchangeModes)
+		z[2] = uint8(l >> 8)
+		z[3] = uint8(l)
+		z[4] = uint8(len(m.pskKeyExchangeModes))
+		copy(z[5:], m.pskKeyExchangeModes)
+		z = z[5+len(m.pskKeyExchangeModes):]
+	}
+	if len(m.pskIdentities) > 0 {
+		// The pre_shared_key extension MUST be the last extension in the
+		// ClientHello. The binders are copied from m.pskBinders as given;
+		// the length prefixes depend only on the binder lengths, so
+		// zero-filled binders of the final lengths can be patched in place
+		// after hashing the ClientHello up to (but not including) the
+		// binders list.
+		z[0] = uint8(extensionPreSharedKey >> 8)
+		z[1] = uint8(extensionPreSharedKey)
+		z[2] = uint8(pskExtensionLength >> 8)
+		z[3] = uint8(pskExtensionLength)
+		z = z[4:]
+
+		idLenPos := z[:2]
+		z = z[2:]
+		idStart := len(z)
+		for _, psk := range m.pskIdentities {
+			z[0] = uint8(len(psk.label) >> 8)
+			z[1] = uint8(len(psk.label))
+			copy(z[2:], psk.label)
+			z = z[2+len(psk.label):]
+			z[0] = uint8(psk.obfuscatedTicketAge >> 24)
+			z[1] = uint8(psk.obfuscatedTicketAge >> 16)
+			z[2] = uint8(psk.obfuscatedTicketAge >> 8)
+			z[3] = uint8(psk.obfuscatedTicketAge)
+			z = z[4:]
+		}
+		idLen := idStart - len(z)
+		idLenPos[0] = uint8(idLen >> 8)
+		idLenPos[1] = uint8(idLen)
+
+		binderLenPos := z[:2]
+		z = z[2:]
+		binderStart := len(z)
+		for _, binder := range m.pskBinders {
+			z[0] = uint8(len(binder))
+			copy(z[1:], binder)
+			z = z[1+len(binder):]
+		}
+		binderLen := binderStart - len(z)
+		binderLenPos[0] = uint8(binderLen >> 8)
+		binderLenPos[1] = uint8(binderLen)
+	}
+
+	m.raw = x
+
+	return x
+}
+
+func (m *clientHelloMsg) unmarshal(data []byte) bool {
+	if len(data) < 42 {
+		return false
+	}
+	m.raw = data
+	m.vers = uint16(data[4])<<8 | uint16(data[5])
+	m.random = data[6:38]
+	sessionIdLen := int(data[38])
+	if sessionIdLen > 32 || len(data) < 39+sessionIdLen {
+		return false
+	}
+	m.sessionId = data[39 : 39+sessionIdLen]
+	data = data[39+sessionIdLen:]
+	if len(data) < 2 {
+		return false
+	}
+	// cipherSuiteLen is the number of bytes of cipher suite numbers. Since
+	// they are uint16s, the number must be even.
+	cipherSuiteLen := int(data[0])<<8 | int(data[1])
+	if cipherSuiteLen%2 == 1 || len(data) < 2+cipherSuiteLen {
+		return false
+	}
+	numCipherSuites := cipherSuiteLen / 2
+	m.cipherSuites = make([]uint16, numCipherSuites)
+	for i := 0; i < numCipherSuites; i++ {
+		m.cipherSuites[i] = uint16(data[2+2*i])<<8 | uint16(data[3+2*i])
+	}
+	data = data[2+cipherSuiteLen:]
+	if len(data) < 1 {
+		return false
+	}
+	compressionMethodsLen := int(data[0])
+	if len(data) < 1+compressionMethodsLen {
+		return false
+	}
+	m.compressionMethods = data[1 : 1+compressionMethodsLen]
+
+	data = data[1+compressionMethodsLen:]
+
+	m.nextProtoNeg = false
+	m.serverName = ""
+	m.ocspStapling = false
+	m.ticketSupported = false
+	m.sessionTicket = nil
+	m.signatureAndHashes = nil
+	m.alpnProtocols = nil
+	m.keyShares = nil
+	m.supportedVersions = nil
+	m.pskKeyExchangeModes = nil
+	m.pskIdentities = nil
+	m.pskBinders = nil
+	m.greaseExtension = 0
+	m.greaseExtensionData = nil
+
+	if len(data) == 0 {
+		// ClientHello is optionally followed by extension data
+		return true
+	}
+	if len(data) < 2 {
+		return false
+	}
+
+	extensionsLength := int(data[0])<<8 | int(data[1])
+	data = data[2:]
+	if extensionsLength != len(data) {
+		return false
+	}
+
+	for len(data) != 0 {
+		if len(data) < 4 {
+			return false
+		}
+		extension := uint16(data[0])<<8 | uint16(data[1])
+		length := int(data[2])<<8 | int(data[3])
+		data = data[4:]
+		if len(data) < length {
+			return false
+		}
+
+		switch extension {
+		case extensionServerName:
+			d := data[:length]
+			if len(d) < 2 {
+				return false
+			}
+			namesLen := int(d[0])<<8 | int(d[1])
+			d = d[2:]
+			if len(d) != namesLen {
+				return false
+			}
+			for len(d) > 0 {
+				if len(d) < 3 {
+					return false
+				}
+				nameType := d[0]
+				nameLen := int(d[1])<<8 | int(d[2])
+				d = d[3:]
+				if len(d) < nameLen {
+					return false
+				}
+				if nameType == 0 {
+					m.serverName = string(d[:nameLen])
+					break
+				}
+				d = d[nameLen:]
+			}
+		case extensionNextProtoNeg:
+			if length > 0 {
+				return false
+			}
+			m.nextProtoNeg = true
+		case extensionStatusRequest:
+			m.ocspStapling = length > 0 && data[0] == statusTypeOCSP
+		case extensionSupportedCurves:
+			d := data[:length]
+			if len(d) < 2 {
+				return false
+			}
+			l := int(d[0])<<8 | int(d[1])
+			if l%2 == 1 || len(d) != l+2 {
+				return false
+			}
+			numCurves := l / 2
+			m.supportedCurves = make([]CurveID, numCurves)
+			d = d[2:]
+			for i := 0; i < numCurves; i++ {
+				m.supportedCurves[i] = CurveID(d[0])<<8 | CurveID(d[1])
+				d = d[2:]
+			}
+		case extensionSupportedPoints:
+			d := data[:length]
+			if len(d) < 1 {
+				return false
+			}
+			l := int(d[0])
+			if len(d) != l+1 {
+				return false
+			}
+			m.supportedPoints = make([]uint8, l)
+			copy(m.supportedPoints, d[1:])
+		case extensionSessionTicket:
+			m.ticketSupported = true
+			m.sessionTicket = data[:length]
+		case extensionSignatureAlgorithms:
+			d := data[:length]
+			if len(d) < 2 {
+				return false
+			}
+			l := int(d[0])<<8 | int(d[1])
+			if l%2 == 1 || len(d) != l+2 {
+				return false
+			}
+			n := l / 2
+			d = d[2:]
+			m.signatureAndHashes = make([]signatureAndHash, n)
+			for i := range m.signatureAndHashes {
+				m.signatureAndHashes[i].hash = d[0]
+				m.signatureAndHashes[i].signature = d[1]
+				d = d[2:]
+			}
+		case extensionALPN:
+			d := data[:length]
+			if len(d) < 2 {
+				return false
+			}
+			l := int(d[0])<<8 | int(d[1])
+			if len(d) != l+2 {
+				return false
+			}
+			d = d[2:]
+			for len(d) != 0 {
+				stringLen := int(d[0])
+				d = d[1:]
+				if stringLen == 0 || stringLen > len(d) {
+					return false
+				}
+				m.alpnProtocols = append(m.alpnProtocols, string(d[:stringLen]))
+				d = d[stringLen:]
+			}
+		case extensionKeyShare:
+			d := data[:length]
+			if len(d) < 2 {
+				return false
+			}
+			l := int(d[0])<<8 | int(d[1])
+			if len(d) != l+2 {
+				return false
+			}
+			d = d[2:]
+			for len(d) != 0 {
+				if len(d) < 4 {
+					return false
+				}
+				group := CurveID(d[0])<<8 | CurveID(d[1])
+				dataLen := int(d[2])<<8 | int(d[3])
+				d = d[4:]
+				if len(d) < dataLen {
+					return false
+				}
+				m.keyShares = append(m.keyShares, keyShare{group: group, data: d[:dataLen]})
+				d = d[dataLen:]
+			}
+		case extensionSupportedVersions:
+			d := data[:length]
+			if len(d) < 1 {
+				return false
+			}
+			l := int(d[0])
+			if len(d) != l+1 || l%2 == 1 {
+				return false
+			}
+			d = d[1:]
+			for len(d) != 0 {
+				m.supportedVersions = append(m.supportedVersions, uint16(d[0])<<8|uint16(d[1]))
+				d = d[2:]
+			}
+		case extensionPSKModes:
+			d := data[:length]
+			if len(d) < 1 {
+				return false
+			}
+			l := int(d[0])
+			if len(d) != l+1 {
+				return false
+			}
+			m.pskKeyExchangeModes = make([]uint8, l)
+			copy(m.pskKeyExchangeModes, d[1:])
+		case extensionPreSharedKey:
+			d := data[:length]
+			if len(d) < 2 {
+				return false
+			}
+			identitiesLen := int(d[0])<<8 | int(d[1])
+			d = d[2:]
+			if len(d) < identitiesLen {
+				return false
+			}
+			ids := d[:identitiesLen]
+			d = d[identitiesLen:]
+			for len(ids) != 0 {
+				if len(ids) < 2 {
+					return false
+				}
+				labelLen := int(ids[0])<<8 | int(ids[1])
+				ids = ids[2:]
+				if len(ids) < labelLen+4 {
+					return false
+				}
+				label := ids[:labelLen]
+				ids = ids[labelLen:]
+				age := uint32(ids[0])<<24 | uint32(ids[1])<<16 | uint32(ids[2])<<8 | uint32(ids[3])
+				ids = ids[4:]
+				m.pskIdentities = append(m.pskIdentities, pskIdentity{label: label, obfuscatedTicketAge: age})
+			}
+			if len(d) < 2 {
+				return false
+			}
+			bindersLen := int(d[0])<<8 | int(d[1])
+			d = d[2:]
+			if len(d) != bindersLen {
+				return false
+			}
+			for len(d) != 0 {
+				binderLen := int(d[0])
+				d = d[1:]
+				if len(d) < binderLen {
+					return false
+				}
+				m.pskBinders = append(m.pskBinders, d[:binderLen])
+				d = d[binderLen:]
+			}
+		default:
+			if isGREASEValue(extension) {
+				m.greaseExtension = extension
+				m.greaseExtensionData = append([]byte(nil), data[:length]...)
+			}
+		}
+		data = data[length:]
+	}
+
+	// A non-GREASE key_share group that isn't offered in supported_groups
+	// is invalid (RFC 8446, section 4.2.8). GREASE groups are always
+	// exempt, since peers are required to ignore them regardless.
+	if !m.grease {
+		for _, ks := range m.keyShares {
+			if isGREASEValue(uint16(ks.group)) {
+				continue
+			}
+			found := false
+			for _, c := range m.supportedCurves {
+				if c == ks.group {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+type serverHelloMsg struct {
+	raw               []byte
+	vers              uint16
+	random            []byte
+	sessionId         []byte
+	cipherSuite       uint16
+	compressionMethod uint8
+	nextProtoNeg      bool
+	nextProtos        []string
+	ocspStapling      bool
+	ticketSupported   bool
+	alpnProtocol      string
+}
+
+func (m *serverHelloMsg) equal(i interface{}) bool {
+	m1, ok := i.(*serverHelloMsg)
+	if !ok {
+		return false
+	}
+
+	return bytes.Equal(m.raw, m1.raw) &&
+		m.vers == m1.vers &&
+		bytes.Equal(m.random, m1.random) &&
+		bytes.Equal(m.sessionId, m1.sessionId) &&
+		m.cipherSuite == m1.cipherSuite &&
+		m.compressionMethod == m1.compressionMethod &&
+		m.nextProtoNeg == m1.nextProtoNeg &&
+		eqStrings(m.nextProtos, m1.nextProtos) &&
+		m.ocspStapling == m1.ocspStapling &&
+		m.ticketSupported == m1.ticketSupported &&
+		m.alpnProtocol == m1.alpnProtocol
+}
+
+func (m *serverHelloMsg) marshal() []byte {
+	if m.raw != nil {
+		return m.raw
+	}
+
+	length := 38 + len(m.sessionId)
+	numExtensions := 0
+	extensionsLength := 0
+
+	nextProtoLen := 0
+	if m.nextProtoNeg {
+		numExtensions++
+		for _, v := range m.nextProtos {
+			nextProtoLen += len(v)
+		}
+		nextProtoLen += len(m.nextProtos)
+		extensionsLength += nextProtoLen
+	}
+	if m.ocspStapling {
+		numExtensions++
+	}
+	if m.ticketSupported {
+		numExtensions++
+	}
+	if alpnLen := len(m.alpnProtocol); alpnLen > 0 {
+		if alpnLen >= 256 {
+			panic("invalid ALPN protocol")
+		}
+		extensionsLength += 2 + 1 + alpnLen
+		numExtensions++
+	}
+
+	if numExtensions > 0 {
+		extensionsLength += 4 * numExtensions
+		length += 2 + extensionsLength
+	}
+
+	x := make([]byte, 4+length)
+	x[0] = typeServerHello
+	x[1] = uint8(length >> 16)
+	x[2] = uint8(length >> 8)
+	x[3] = uint8(length)
+	x[4] = uint8(m.vers >> 8)
+	x[5] = uint8(m.vers)
+	copy(x[6:38], m.random)
+	x[38] = uint8(len(m.sessionId))
+	copy(x[39:39+len(m.sessionId)], m.sessionId)
+	z := x[39+len(m.sessionId):]
+	z[0] = uint8(m.cipherSuite >> 8)
+	z[1] = uint8(m.cipherSuite)
+	z[2] = uint8(m.compressionMethod)
+
+	z = z[3:]
+	if numExtensions > 0 {
+		z[0] = uint8(extensionsLength >> 8)
+		z[1] = uint8(extensionsLength)
+		z = z[2:]
+	}
+	if m.nextProtoNeg {
+		ext := extensionNextProtoNeg
+		z[0] = uint8(ext >> 8)
+		z[1] = uint8(ext)
+		z[2] = uint8(nextProtoLen >> 8)
+		z[3] = uint8(nextProtoLen)
+		z = z[4:]
+
+		for _, v := range m.nextProtos {
+			l := len(v)
+			if l > 255 {
+				l = 255
+			}
+			z[0] = uint8(l)
+			copy(z[1:], []byte(v[0:l]))
+			z = z[1+l:]
+		}
+	}
+	if m.ocspStapling {
+		z[0] = uint8(extensionStatusRequest >> 8)
+		z[1] = uint8(extensionStatusRequest)
+		z = z[4:]
+	}
+	if m.ticketSupported {
+		z[0] = uint8(extensionSessionTicket >> 8)
+		z[1] = uint8(extensionSessionTicket)
+		z = z[4:]
+	}
+	if alpnLen := len(m.alpnProtocol); alpnLen > 0 {
+		z[0] = uint8(extensionALPN >> 8)
+		z[1] = uint8(extensionALPN)
+		l := 2 + 1 + alpnLen
+		z[2] = uint8(l >> 8)
+		z[3] = uint8(l)
+
+		l -= 2
+		z[4] = uint8(l >> 8)
+		z[5] = uint8(l)
+
+		l -= 1
+		z[6] = uint8(l)
+		copy(z[7:], []byte(m.alpnProtocol))
+	}
+
+	m.raw = x
+
+	return x
+}
+
+func (m *serverHelloMsg) unmarshal(data []byte) bool {
+	if len(data) < 42 {
+		return false
+	}
+	m.raw = data
+	m.vers = uint16(data[4])<<8 | uint16(data[5])
+	m.random = data[6:38]
+	sessionIdLen := int(data[38])
+	if sessionIdLen > 32 || len(data) < 39+sessionIdLen {
+		return false
+	}
+	m.sessionId = data[39 : 39+sessionIdLen]
+	data = data[39+sessionIdLen:]
+	if len(data) < 3 {
+		return false
+	}
+	m.cipherSuite = uint16(data[0])<<8 | uint16(data[1])
+	m.compressionMethod = data[2]
+	data = data[3:]
+
+	m.nextProtoNeg = false
+	m.nextProtos = nil
+	m.ocspStapling = false
+	m.ticketSupported = false
+	m.alpnProtocol = ""
+
+	if len(data) == 0 {
+		// ServerHello is optionally followed by extension data
+		return true
+	}
+	if len(data) < 2 {
+		return false
+	}
+
+	extensionsLength := int(data[0])<<8 | int(data[1])
+	data = data[2:]
+	if len(data) != extensionsLength {
+		return false
+	}
+
+	for len(data) != 0 {
+		if len(data) < 4 {
+			return false
+		}
+		extension := uint16(data[0])<<8 | uint16(data[1])
+		length := int(data[2])<<8 | int(data[3])
+		data = data[4:]
+		if len(data) < length {
+			return false
+		}
+
+		switch extension {
+		case extensionNextProtoNeg:
+			m.nextProtoNeg = true
+			d := data[:length]
+			for len(d) > 0 {
+				l := int(d[0])
+				d = d[1:]
+				if l == 0 || l > len(d) {
+					return false
+				}
+				m.nextProtos = append(m.nextProtos, string(d[:l]))
+				d = d[l:]
+			}
+		case extensionStatusRequest:
+			m.ocspStapling = true
+		case extensionSessionTicket:
+			m.ticketSupported = true
+		case extensionALPN:
+			d := data[:length]
+			if len(d) < 3 {
+				return false
+			}
+			l := int(d[0])<<8 | int(d[1])
+			if l != len(d)-2 {
+				return false
+			}
+			d = d[2:]
+			l = int(d[0])
+			if l != len(d)-1 {
+				return false
+			}
+			d = d[1:]
+			if len(d) == 0 {
+				return false
+			}
+			m.alpnProtocol = string(d)
+		}
+		data = data[length:]
+	}
+
+	return true
+}
+
+// serverHelloMsg13 represents the TLS 1.3 ServerHello message, as defined in
+// https://tools.ietf.org/html/draft-ietf-tls-tls13. A ServerHello whose
+// random value is the special HelloRetryRequest constant is handled
+// separately by helloRetryRequestMsg.
+type serverHelloMsg13 struct {
+	raw                 []byte
+	vers                uint16
+	random              []byte
+	cipherSuite         uint16
+	keyShare            keyShare
+	signatureAlgorithms bool
+	selectedIdentity    uint16
+	pskSelected         bool
+}
+
+func (m *serverHelloMsg13) equal(i interface{}) bool {
+	m1, ok := i.(*serverHelloMsg13)
+	if !ok {
+		return false
+	}
+
+	return bytes.Equal(m.raw, m1.raw) &&
+		m.vers == m1.vers &&
+		bytes.Equal(m.random, m1.random) &&
+		m.cipherSuite == m1.cipherSuite &&
+		m.keyShare.group == m1.keyShare.group &&
+		bytes.Equal(m.keyShare.data, m1.keyShare.data) &&
+		m.signatureAlgorithms == m1.signatureAlgorithms &&
+		m.pskSelected == m1.pskSelected &&
+		m.selectedIdentity == m1.selectedIdentity
+}
+
+func (m *serverHelloMsg13) marshal() []byte {
+	if m.raw != nil {
+		return m.raw
+	}
+
+	// legacy_session_id_echo is always empty here, legacy_compression_method
+	// is always null (0).
+	length := 2 + 32 + 1 + 2 + 1
+
+	// supported_versions (6 bytes) + key_share (8 bytes header + data)
+	extensionsLength := 6 + 8 + len(m.keyShare.data)
+	if m.signatureAlgorithms {
+		extensionsLength += 4 // signature_algorithms (empty, for round-trip purposes)
+	}
+	if m.pskSelected {
+		extensionsLength += 6 // pre_shared_key (4 byte header + 2 byte selected_identity)
+	}
+	length += 2 + extensionsLength
+
+	x := make([]byte, 4+length)
+	x[0] = typeServerHello
+	x[1] = uint8(length >> 16)
+	x[2] = uint8(length >> 8)
+	x[3] = uint8(length)
+	x[4] = uint8(m.vers >> 8)
+	x[5] = uint8(m.vers)
+	copy(x[6:38], m.random)
+	// legacy_session_id_echo length
+	x[38] = 0
+	z := x[39:]
+	z[0] = uint8(m.cipherSuite >> 8)
+	z[1] = uint8(m.cipherSuite)
+	z[2] = 0 // legacy_compression_method
+	z = z[3:]
+
+	z[0] = uint8(extensionsLength >> 8)
+	z[1] = uint8(extensionsLength)
+	z = z[2:]
+
+	z[0] = uint8(extensionSupportedVersions >> 8)
+	z[1] = uint8(extensionSupportedVersions)
+	z[2] = 0
+	z[3] = 2
+	z[4] = uint8(m.vers >> 8)
+	z[5] = uint8(m.vers)
+	z = z[6:]
+
+	z[0] = uint8(extensionKeyShare >> 8)
+	z[1] = uint8(extensionKeyShare)
+	l := 4 + len(m.keyShare.data)
+	z[2] = uint8(l >> 8)
+	z[3] = uint8(l)
+	z[4] = uint8(m.keyShare.group >> 8)
+	z[5] = uint8(m.keyShare.group)
+	z[6] = uint8(len(m.keyShare.data) >> 8)
+	z[7] = uint8(len(m.keyShare.data))
+	copy(z[8:], m.keyShare.data)
+	z = z[8+len(m.keyShare.data):]
+
+	if m.signatureAlgorithms {
+		z[0] = uint8(extensionSignatureAlgorithms >> 8)
+		z[1] = uint8(extensionSignatureAlgorithms)
+		// The length is always 0 here.
+		z = z[4:]
+	}
+
+	if m.pskSelected {
+		z[0] = uint8(extensionPreSharedKey >> 8)
+		z[1] = uint8(extensionPreSharedKey)
+		z[2] = 0
+		z[3] = 2
+		z[4] = uint8(m.selectedIdentity >> 8)
+		z[5] = uint8(m.selectedIdentity)
+	}
+
+	m.raw = x
+	return x
+}
+
+func (m *serverHelloMsg13) unmarshal(data []byte) bool {
+	if len(data) < 41 {
+		return false
+	}
+	m.raw = data
+	m.vers = uint16(data[4])<<8 | uint16(data[5])
+	m.random = data[6:38]
+	sessionIdLen := int(data[38])
+	if len(data) < 39+sessionIdLen+3 {
+		return false
+	}
+	data = data[39+sessionIdLen:]
+	m.cipherSuite = uint16(data[0])<<8 | uint16(data[1])
+	// data[2] is legacy_compression_method
+	data = data[3:]
+
+	m.keyShare = keyShare{}
+	m.signatureAlgorithms = false
+	m.pskSelected = false
+	m.selectedIdentity = 0
+
+	if len(data) < 2 {
+		return false
+	}
+	extensionsLength := int(data[0])<<8 | int(data[1])
+	data = data[2:]
+	if len(data) != extensionsLength {
+		return false
+	}
+
+	for len(data) != 0 {
+		if len(data) < 4 {
+			return false
+		}
+		extension := uint16(data[0])<<8 | uint16(data[1])
+		length := int(data[2])<<8 | int(data[3])
+		data = data[4:]
+		if len(data) < length {
+			return false
+		}
+
+		switch extension {
+		case extensionSupportedVersions:
+			if length != 2 {
+				return false
+			}
+			m.vers = uint16(data[0])<<8 | uint16(data[1])
+		case extensionKeyShare:
+			d := data[:length]
+			if len(d) < 4 {
+				return false
+			}
+			m.keyShare.group = CurveID(d[0])<<8 | CurveID(d[1])
+			dataLen := int(d[2])<<8 | int(d[3])
+			d = d[4:]
+			if len(d) != dataLen {
+				return false
+			}
+			m.keyShare.data = d
+		case extensionSignatureAlgorithms:
+			m.signatureAlgorithms = true
+		case extensionPreSharedKey:
+			if length != 2 {
+				return false
+			}
+			m.pskSelected = true
+			m.selectedIdentity = uint16(data[0])<<8 | uint16(data[1])
+		}
+		data = data[length:]
+	}
+
+	return true
+}
+
+// helloRetryRandom is the fixed Random value that identifies a
+// HelloRetryRequest masquerading as a ServerHello, defined as
+// SHA-256("HelloRetryRequest") in RFC 8446, section 4.1.3.
+var helloRetryRandom = [32]byte{
+	0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11,
+	0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8, 0x91,
+	0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e,
+	0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c,
+}
+
+// helloRetryRequestMsg is the TLS 1.3 HelloRetryRequest, which the server
+// sends in place of a ServerHello to ask the client to retry ClientHello
+// with a different key_share group (and, optionally, an echoed cookie).
+// It is distinguished on the wire from a real ServerHello only by its
+// random field being helloRetryRandom; unlike serverHelloMsg13 it never
+// carries a key-exchange value, only the selected group. See RFC 8446,
+// section 4.1.4.
+type helloRetryRequestMsg struct {
+	raw           []byte
+	vers          uint16
+	sessionId     []byte
+	cipherSuite   uint16
+	selectedGroup CurveID
+	cookie        []byte
+}
+
+func (m *helloRetryRequestMsg) equal(i interface{}) bool {
+	m1, ok := i.(*helloRetryRequestMsg)
+	if !ok {
+		return false
+	}
+
+	return bytes.Equal(m.raw, m1.raw) &&
+		m.vers == m1.vers &&
+		bytes.Equal(m.sessionId, m1.sessionId) &&
+		m.cipherSuite == m1.cipherSuite &&
+		m.selectedGroup == m1.selectedGroup &&
+		bytes.Equal(m.cookie, m1.cookie)
+}
+
+func (m *helloRetryRequestMsg) marshal() []byte {
+	if m.raw != nil {
+		return m.raw
+	}
+
+	// legacy_session_id_echo, cipher_suite, legacy_compression_method
+	length := 2 + 32 + 1 + len(m.sessionId) + 2 + 1
+
+	// supported_versions (6 bytes) + key_share selected_group (6 bytes)
+	extensionsLength := 6 + 6
+	if len(m.cookie) > 0 {
+		extensionsLength += 4 + 2 + len(m.cookie)
+	}
+	length += 2 + extensionsLength
+
+	x := make([]byte, 4+length)
+	x[0] = typeServerHello
+	x[1] = uint8(length >> 16)
+	x[2] = uint8(length >> 8)
+	x[3] = uint8(length)
+	x[4] = uint8(m.vers >> 8)
+	x[5] = uint8(m.vers)
+	copy(x[6:38], helloRetryRandom[:])
+	x[38] = uint8(len(m.sessionId))
+	z := x[39:]
+	copy(z, m.sessionId)
+	z = z[len(m.sessionId):]
+	z[0] = uint8(m.cipherSuite >> 8)
+	z[1] = uint8(m.cipherSuite)
+	z[2] = 0 // legacy_compression_method
+	z = z[3:]
+
+	z[0] = uint8(extensionsLength >> 8)
+	z[1] = uint8(extensionsLength)
+	z = z[2:]
+
+	z[0] = uint8(extensionSupportedVersions >> 8)
+	z[1] = uint8(extensionSupportedVersions)
+	z[2] = 0
+	z[3] = 2
+	z[4] = uint8(m.vers >> 8)
+	z[5] = uint8(m.vers)
+	z = z[6:]
+
+	z[0] = uint8(extensionKeyShare >> 8)
+	z[1] = uint8(extensionKeyShare)
+	z[2] = 0
+	z[3] = 2
+	z[4] = uint8(m.selectedGroup >> 8)
+	z[5] = uint8(m.selectedGroup)
+	z = z[6:]
+
+	if len(m.cookie) > 0 {
+		z[0] = uint8(extensionCookie >> 8)
+		z[1] = uint8(extensionCookie)
+		l := 2 + len(m.cookie)
+		z[2] = uint8(l >> 8)
+		z[3] = uint8(l)
+		z[4] = uint8(len(m.cookie) >> 8)
+		z[5] = uint8(len(m.cookie))
+		copy(z[6:], m.cookie)
+		z = z[6+len(m.cookie):]
+	}
+
+	m.raw = x
+	return x
+}
+
+func (m *helloRetryRequestMsg) unmarshal(data []byte) bool {
+	if len(data) < 41 {
+		return false
+	}
+	m.raw = data
+	m.vers = uint16(data[4])<<8 | uint16(data[5])
+	if !bytes.Equal(data[6:38], helloRetryRandom[:]) {
+		return false
+	}
+	sessionIdLen := int(data[38])
+	if sessionIdLen > 32 || len(data) < 39+sessionIdLen+3 {
+		return false
+	}
+	m.sessionId = data[39 : 39+sessionIdLen]
+	data = data[39+sessionIdLen:]
+	m.cipherSuite = uint16(data[0])<<8 | uint16(data[1])
+	// data[2] is legacy_compression_method
+	data = data[3:]
+
+	m.selectedGroup = 0
+	m.cookie = nil
+
+	if len(data) < 2 {
+		return false
+	}
+	extensionsLength := int(data[0])<<8 | int(data[1])
+	data = data[2:]
+	if len(data) != extensionsLength {
+		return false
+	}
+
+	sawKeyShare := false
+	for len(data) != 0 {
+		if len(data) < 4 {
+			return false
+		}
+		extension := uint16(data[0])<<8 | uint16(data[1])
+		length := int(data[2])<<8 | int(data[3])
+		data = data[4:]
+		if len(data) < length {
+			return false
+		}
+
+		switch extension {
+		case extensionSupportedVersions:
+			if length != 2 {
+				return false
+			}
+			m.vers = uint16(data[0])<<8 | uint16(data[1])
+		case extensionKeyShare:
+			if length != 2 {
+				return false
+			}
+			m.selectedGroup = CurveID(data[0])<<8 | CurveID(data[1])
+			sawKeyShare = true
+		case extensionCookie:
+			d := data[:length]
+			if len(d) < 2 {
+				return false
+			}
+			l := int(d[0])<<8 | int(d[1])
+			if len(d) != l+2 {
+				return false
+			}
+			m.cookie = d[2:]
+		}
+		data = data[length:]
+	}
+	if !sawKeyShare {
+		return false
+	}
+
+	return true
+}
+
+type finishedMsg struct {
+	raw        []byte
+	verifyData []byte
+}
+
+func (m *finishedMsg) equal(i interface{}) bool {
+	m1, ok := i.(*finishedMsg)
+	if !ok {
+		return false
+	}
+
+	return bytes.Equal(m.verifyData, m1.verifyData)
+}
+
+func (m *finishedMsg) marshal() []byte {
+	if m.raw != nil {
+		return m.raw
+	}
+
+	x := make([]byte, 4+len(m.verifyData))
+	x[0] = typeFinished
+	x[3] = byte(len(m.verifyData))
+	copy(x[4:], m.verifyData)
+	m.raw = x
+	return x
+}
+
+func (m *finishedMsg) unmarshal(data []byte) bool {
+	m.raw = data
+	if len(data) < 4 {
+		return false
+	}
+	m.verifyData = data[4:]
+	return true
+}
+
+// endOfEarlyDataMsg represents the TLS 1.3 EndOfEarlyData message, an empty
+// handshake message sent by the client to signal the end of early (0-RTT)
+// application data, as defined in
+// https://tools.ietf.org/html/draft-ietf-tls-tls13.
+type endOfEarlyDataMsg struct{}
+
+func (*endOfEarlyDataMsg) equal(i interface{}) bool {
+	_, ok := i.(*endOfEarlyDataMsg)
+	return ok
+}
+
+func (*endOfEarlyDataMsg) marshal() []byte {
+	return []byte{typeEndOfEarlyData, 0, 0, 0}
+}
+
+func (*endOfEarlyDataMsg) unmarshal(data []byte) bool {
+	return len(data) == 4 && data[1] == 0 && data[2] == 0 && data[3] == 0
+}
+
+type encryptedExtensionsMsg struct {
+	raw          []byte
+	alpnProtocol string
+}
+
+func (m *encryptedExtensionsMsg) equal(i interface{}) bool {
+	m1, ok := i.(*encryptedExtensionsMsg)
+	if !ok {
+		return false
+	}
+
+	return m.alpnProtocol == m1.alpnProtocol
+}
+
+func (m *encryptedExtensionsMsg) marshal() []byte {
+	if m.raw != nil {
+		return m.raw
+	}
+
+	extensionsLength := 0
+	if alpnLen := len(m.alpnProtocol); alpnLen > 0 {
+		extensionsLength = 2 + 1 + alpnLen + 4
+	}
+
+	length := 2 + extensionsLength
+	x := make([]byte, 4+length)
+	x[0] = typeEncryptedExtensions
+	x[1] = uint8(length >> 16)
+	x[2] = uint8(length >> 8)
+	x[3] = uint8(length)
+	x[4] = uint8(extensionsLength >> 8)
+	x[5] = uint8(extensionsLength)
+
+	z := x[6:]
+	if alpnLen := len(m.alpnProtocol); alpnLen > 0 {
+		z[0] = uint8(extensionALPN >> 8)
+		z[1] = uint8(extensionALPN)
+		l := 2 + 1 + alpnLen
+		z[2] = uint8(l >> 8)
+		z[3] = uint8(l)
+
+		l -= 2
+		z[4] = uint8(l >> 8)
+		z[5] = uint8(l)
+
+		l--
+		z[6] = uint8(l)
+		copy(z[7:], []byte(m.alpnProtocol))
+	}
+
+	m.raw = x
+	return x
+}
+
+func (m *encryptedExtensionsMsg) unmarshal(data []byte) bool {
+	m.raw = data
+	m.alpnProtocol = ""
+	if len(data) < 6 {
+		return false
+	}
+	extensionsLength := int(data[4])<<8 | int(data[5])
+	data = data[6:]
+	if len(data) != extensionsLength {
+		return false
+	}
+
+	for len(data) != 0 {
+		if len(data) < 4 {
+			return false
+		}
+		extension := uint16(data[0])<<8 | uint16(data[1])
+		length := int(data[2])<<8 | int(data[3])
+		data = data[4:]
+		if len(data) < length {
+			return false
+		}
+
+		if extension == extensionALPN {
+			d := data[:length]
+			if len(d) < 3 {
+				return false
+			}
+			l := int(d[0])<<8 | int(d[1])
+			if l != len(d)-2 {
+				return false
+			}
+			d = d[2:]
+			l = int(d[0])
+			if l != len(d)-1 {
+				return false
+			}
+			d = d[1:]
+			if len(d) == 0 {
+				return false
+			}
+			m.alpnProtocol = string(d)
+		}
+		data = data[length:]
+	}
+
+	return true
+}
+
+type certificateMsg struct {
+	raw          []byte
+	certificates [][]byte
+}
+
+func (m *certificateMsg) equal(i interface{}) bool {
+	m1, ok := i.(*certificateMsg)
+	if !ok {
+		return false
+	}
+
+	return eqByteSlices(m.certificates, m1.certificates)
+}
+
+func (m *certificateMsg) marshal() (x []byte) {
+	if m.raw != nil {
+		return m.raw
+	}
+
+	var i int
+	for _, slice := range m.certificates {
+		i += len(slice)
+	}
+
+	length := 3 + 3*len(m.certificates) + i
+	x = make([]byte, 4+length)
+	x[0] = typeCertificate
+	x[1] = uint8(length >> 16)
+	x[2] = uint8(length >> 8)
+	x[3] = uint8(length)
+
+	certificateOctets := length - 3
+	x[4] = uint8(certificateOctets >> 16)
+	x[5] = uint8(certificateOctets >> 8)
+	x[6] = uint8(certificateOctets)
+
+	y := x[7:]
+	for _, slice := range m.certificates {
+		y[0] = uint8(len(slice) >> 16)
+		y[1] = uint8(len(slice) >> 8)
+		y[2] = uint8(len(slice))
+		copy(y[3:], slice)
+		y = y[3+len(slice):]
+	}
+
+	m.raw = x
+	return
+}
+
+func (m *certificateMsg) unmarshal(data []byte) bool {
+	if len(data) < 7 {
+		return false
+	}
+
+	m.raw = data
+	certsLen := uint32(data[4])<<16 | uint32(data[5])<<8 | uint32(data[6])
+	if uint32(len(data)) != certsLen+7 {
+		return false
+	}
+
+	numCerts := 0
+	d := data[7:]
+	for certsLen > 0 {
+		if len(d) < 4 {
+			return false
+		}
+		certLen := uint32(d[0])<<16 | uint32(d[1])<<8 | uint32(d[2])
+		if uint32(len(d)) < 3+certLen {
+			return false
+		}
+		d = d[3+certLen:]
+		certsLen -= 3 + certLen
+		numCerts++
+	}
+
+	m.certificates = make([][]byte, numCerts)
+	d = data[7:]
+	for i := 0; i < numCerts; i++ {
+		certLen := uint32(d[0])<<16 | uint32(d[1])<<8 | uint32(d[2])
+		m.certificates[i] = d[3 : 3+certLen]
+		d = d[3+certLen:]
+	}
+
+	return true
+}
+
+// certificateMsg13 represents the TLS 1.3 Certificate message, as defined in
+// https://tools.ietf.org/html/draft-ietf-tls-tls13.
+type certificateMsg13 struct {
+	raw            []byte
+	requestContext []byte
+	certificates   [][]byte
+}
+
+func (m *certificateMsg13) equal(i interface{}) bool {
+	m1, ok := i.(*certificateMsg13)
+	if !ok {
+		return false
+	}
+
+	return bytes.Equal(m.requestContext, m1.requestContext) &&
+		eqByteSlices(m.certificates, m1.certificates)
+}
+
+func (m *certificateMsg13) marshal() []byte {
+	if m.raw != nil {
+		return m.raw
+	}
+
+	var certsLen int
+	for _, slice := range m.certificates {
+		certsLen += 3 + len(slice) + 2 // cert length + cert + empty extensions
+	}
+
+	length := 1 + len(m.requestContext) + 3 + certsLen
+	x := make([]byte, 4+length)
+	x[0] = typeCertificate
+	x[1] = uint8(length >> 16)
+	x[2] = uint8(length >> 8)
+	x[3] = uint8(length)
+
+	x[4] = uint8(len(m.requestContext))
+	copy(x[5:], m.requestContext)
+	y := x[5+len(m.requestContext):]
+
+	y[0] = uint8(certsLen >> 16)
+	y[1] = uint8(certsLen >> 8)
+	y[2] = uint8(certsLen)
+	y = y[3:]
+
+	for _, slice := range m.certificates {
+		y[0] = uint8(len(slice) >> 16)
+		y[1] = uint8(len(slice) >> 8)
+		y[2] = uint8(len(slice))
+		copy(y[3:], slice)
+		y = y[3+len(slice):]
+		// empty extensions for each CertificateEntry
+		y[0] = 0
+		y[1] = 0
+		y = y[2:]
+	}
+
+	m.raw = x
+	return x
+}
+
+func (m *certificateMsg13) unmarshal(data []byte) bool {
+	if len(data) < 5 {
+		return false
+	}
+
+	m.raw = data
+	ctxLen := int(data[4])
+	if len(data) < 5+ctxLen+3 {
+		return false
+	}
+	m.requestContext = data[5 : 5+ctxLen]
+	data = data[5+ctxLen:]
+
+	certsLen := int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+	d := data[3:]
+	if len(d) != certsLen {
+		return false
+	}
+
+	var certificates [][]byte
+	for len(d) > 0 {
+		if len(d) < 5 {
+			return false
+		}
+		certLen := int(d[0])<<16 | int(d[1])<<8 | int(d[2])
+		if len(d) < 3+certLen+2 {
+			return false
+		}
+		certificates = append(certificates, d[3:3+certLen])
+		extLen := int(d[3+certLen])<<8 | int(d[3+certLen+1])
+		if len(d) < 3+certLen+2+extLen {
+			return false
+		}
+		d = d[3+certLen+2+extLen:]
+	}
+
+	m.certificates = certificates
+	return true
+}
+
+type certificateRequestMsg struct {
+	raw []byte
+	// hasSignatureAndHash indicates whether this message includes a list
+	// of signature and hash functions. This change was introduced with TLS
+	// 1.2.
+	hasSignatureAndHash bool
+
+	certificateTypes       []byte
+	signatureAndHashes     []signatureAndHash
+	certificateAuthorities [][]byte
+}
+
+func (m *certificateRequestMsg) equal(i interface{}) bool {
+	m1, ok := i.(*certificateRequestMsg)
+	if !ok {
+		return false
+	}
+
+	return bytes.Equal(m.certificateTypes, m1.certificateTypes) &&
+		eqByteSlices(m.certificateAuthorities, m1.certificateAuthorities) &&
+		eqSignatureAndHashes(m.signatureAndHashes, m1.signatureAndHashes)
+}
+
+func (m *certificateRequestMsg) marshal() (x []byte) {
+	if m.raw != nil {
+		return m.raw
+	}
+
+	length := 1 + len(m.certificateTypes) + 2
+
+	casLength := 0
+	for _, ca := range m.certificateAuthorities {
+		casLength += 2 + len(ca)
+	}
+	length += casLength
+
+	if m.hasSignatureAndHash {
+		length += 2 + 2*len(m.signatureAndHashes)
+	}
+
+	x = make([]byte, 4+length)
+	x[0] = typeCertificateRequest
+	x[1] = uint8(length >> 16)
+	x[2] = uint8(length >> 8)
+	x[3] = uint8(length)
+
+	x[4] = uint8(len(m.certificateTypes))
+
+	copy(x[5:], m.certificateTypes)
+	y := x[5+len(m.certificateTypes):]
+
+	if m.hasSignatureAndHash {
+		n := len(m.signatureAndHashes) * 2
+		y[0] = uint8(n >> 8)
+		y[1] = uint8(n)
+		y = y[2:]
+		for _, sigAndHash := range m.signatureAndHashes {
+			y[0] = sigAndHash.hash
+			y[1] = sigAndHash.signature
+			y = y[2:]
+		}
+	}
+
+	y[0] = uint8(casLength >> 8)
+	y[1] = uint8(casLength)
+	y = y[2:]
+	for _, ca := range m.certificateAuthorities {
+		y[0] = uint8(len(ca) >> 8)
+		y[1] = uint8(len(ca))
+		y = y[2:]
+		copy(y, ca)
+		y = y[len(ca):]
+	}
+
+	m.raw = x
+	return
+}
+
+func (m *certificateRequestMsg) unmarshal(data []byte) bool {
+	if len(data) < 5 {
+		return false
+	}
+	m.raw = data
+
+	length := uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3])
+	if uint32(len(data))-4 != length {
+		return false
+	}
+
+	numCertTypes := int(data[4])
+	data = data[5:]
+	if numCertTypes == 0 || len(data) <= numCertTypes {
+		return false
+	}
+
+	m.certificateTypes = make([]byte, numCertTypes)
+	if copy(m.certificateTypes, data) != numCertTypes {
+		return false
+	}
+
+	data = data[numCertTypes:]
+
+	if m.hasSignatureAndHash {
+		if len(data) < 2 {
+			return false
+		}
+		sigAndHashLen := uint16(data[0])<<8 | uint16(data[1])
+		data = data[2:]
+		if sigAndHashLen&1 != 0 {
+			return false
+		}
+		if len(data) < int(sigAndHashLen) {
+			return false
+		}
+		numSigAndHash := sigAndHashLen / 2
+		m.signatureAndHashes = make([]signatureAndHash, numSigAndHash)
+		for i := range m.signatureAndHashes {
+			m.signatureAndHashes[i].hash = data[0]
+			m.signatureAndHashes[i].signature = data[1]
+			data = data[2:]
+		}
+	}
+
+	if len(data) < 2 {
+		return false
+	}
+	casLength := uint16(data[0])<<8 | uint16(data[1])
+	data = data[2:]
+	if len(data) < int(casLength) {
+		return false
+	}
+	cas := make([]byte, casLength)
+	copy(cas, data)
+	data = data[casLength:]
+
+	m.certificateAuthorities = nil
+	for len(cas) > 0 {
+		if len(cas) < 2 {
+			return false
+		}
+		caLen := uint16(cas[0])<<8 | uint16(cas[1])
+		cas = cas[2:]
+
+		if len(cas) < int(caLen) {
+			return false
+		}
+
+		m.certificateAuthorities = append(m.certificateAuthorities, cas[:caLen])
+		cas = cas[caLen:]
+	}
+
+	return len(data) == 0
+}
+
+type certificateVerifyMsg struct {
+	raw                 []byte
+	hasSignatureAndHash bool
+	signatureAndHash    signatureAndHash
+	signature           []byte
+}
+
+func (m *certificateVerifyMsg) equal(i interface{}) bool {
+	m1, ok := i.(*certificateVerifyMsg)
+	if !ok {
+		return false
+	}
+
+	return bytes.Equal(m.signature, m1.signature)
+}
+
+func (m *certificateVerifyMsg) marshal() (x []byte) {
+	if m.raw != nil {
+		return m.raw
+	}
+
+	siglength := len(m.signature)
+	length := 2 + siglength
+	if m.hasSignatureAndHash {
+		length += 2
+	}
+	x = make([]byte, 4+length)
+	x[0] = typeCertificateVerify
+	x[1] = uint8(length >> 16)
+	x[2] = uint8(length >> 8)
+	x[3] = uint8(length)
+	y := x[4:]
+	if m.hasSignatureAndHash {
+		y[0] = m.signatureAndHash.hash
+		y[1] = m.signatureAndHash.signature
+		y = y[2:]
+	}
+	y[0] = uint8(siglength >> 8)
+	y[1] = uint8(siglength)
+	copy(y[2:], m.signature)
+
+	m.raw = x
+
+	return
+}
+
+func (m *certificateVerifyMsg) unmarshal(data []byte) bool {
+	if len(data) < 6 {
+		return false
+	}
+
+	m.raw = data
+	data = data[4:]
+	if m.hasSignatureAndHash {
+		m.signatureAndHash.hash = data[0]
+		m.signatureAndHash.signature = data[1]
+		data = data[2:]
+	}
+
+	if len(data) < 2 {
+		return false
+	}
+	siglength := int(data[0])<<8 + int(data[1])
+	data = data[2:]
+	if len(data) != siglength {
+		return false
+	}
+
+	m.signature = data
+
+	return true
+}
+
+type clientKeyExchangeMsg struct {
+	raw        []byte
+	ciphertext []byte
+}
+
+func (m *clientKeyExchangeMsg) equal(i interface{}) bool {
+	m1, ok := i.(*clientKeyExchangeMsg)
+	if !ok {
+		return false
+	}
+	return bytes.Equal(m.ciphertext, m1.ciphertext)
+}
+
+func (m *clientKeyExchangeMsg) marshal() []byte {
+	if m.raw != nil {
+		return m.raw
+	}
+	length := len(m.ciphertext)
+	x := make([]byte, length+4)
+	x[0] = typeClientKeyExchange
+	x[1] = uint8(length >> 16)
+	x[2] = uint8(length >> 8)
+	x[3] = uint8(length)
+	copy(x[4:], m.ciphertext)
+
+	m.raw = x
+	return x
+}
+
+func (m *clientKeyExchangeMsg) unmarshal(data []byte) bool {
+	m.raw = data
+	if len(data) < 4 {
+		return false
+	}
+	l := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	if l != len(data)-4 {
+		return false
+	}
+	m.ciphertext = data[4:]
+	return true
+}
+
+type nextProtoMsg struct {
+	raw   []byte
+	proto string
+}
+
+func (m *nextProtoMsg) equal(i interface{}) bool {
+	m1, ok := i.(*nextProtoMsg)
+	if !ok {
+		return false
+	}
+
+	return m.proto == m1.proto
+}
+
+func (m *nextProtoMsg) marshal() []byte {
+	if m.raw != nil {
+		return m.raw
+	}
+	l := len(m.proto)
+	if l > 255 {
+		l = 255
+	}
+
+	padding := 32 - (l+2)%32
+	length := l + padding + 2
+	x := make([]byte, length+4)
+	x[0] = typeNextProtocol
+	x[1] = uint8(length >> 16)
+	x[2] = uint8(length >> 8)
+	x[3] = uint8(length)
+
+	y := x[4:]
+	y[0] = byte(l)
+	copy(y[1:], []byte(m.proto[0:l]))
+	y = y[1+l:]
+	y[0] = byte(padding)
+
+	m.raw = x
+
+	return x
+}
+
+func (m *nextProtoMsg) unmarshal(data []byte) bool {
+	m.raw = data
+
+	if len(data) < 5 {
+		return false
+	}
+	data = data[4:]
+	protoLen := int(data[0])
+
+	if len(data) < 2+protoLen {
+		return false
+	}
+	m.proto = string(data[1 : 1+protoLen])
+
+	paddingLen := int(data[1+protoLen])
+	if len(data) != 2+protoLen+paddingLen {
+		return false
+	}
+
+	return true
+}
+
+type certificateStatusMsg struct {
+	raw        []byte
+	statusType uint8
+	response   []byte
+}
+
+func (m *certificateStatusMsg) equal(i interface{}) bool {
+	m1, ok := i.(*certificateStatusMsg)
+	if !ok {
+		return false
+	}
+
+	return m.statusType == m1.statusType && bytes.Equal(m.response, m1.response)
+}
+
+func (m *certificateStatusMsg) marshal() []byte {
+	if m.raw != nil {
+		return m.raw
+	}
+
+	var x []byte
+	if m.statusType == statusTypeOCSP {
+		length := len(m.response) + 4
+		x = make([]byte, 4+length)
+		x[0] = typeCertificateStatus
+		x[1] = uint8(length >> 16)
+		x[2] = uint8(length >> 8)
+		x[3] = uint8(length)
+		x[4] = statusTypeOCSP
+		x[5] = uint8(len(m.response) >> 16)
+		x[6] = uint8(len(m.response) >> 8)
+		x[7] = uint8(len(m.response))
+		copy(x[8:], m.response)
+	} else {
+		x = []byte{typeCertificateStatus, 0, 0, 1, m.statusType}
+	}
+
+	m.raw = x
+	return x
+}
+
+func (m *certificateStatusMsg) unmarshal(data []byte) bool {
+	m.raw = data
+	if len(data) < 5 {
+		return false
+	}
+	m.statusType = data[4]
+
+	m.response = nil
+	if m.statusType == statusTypeOCSP {
+		if len(data) < 8 {
+			return false
+		}
+		respLen := int(data[5])<<16 | int(data[6])<<8 | int(data[7])
+		if respLen+8 != len(data) {
+			return false
+		}
+		m.response = data[8:]
+	}
+
+	return true
+}
+
+type helloRequestMsg struct{}
+
+func (*helloRequestMsg) equal(i interface{}) bool {
+	_, ok := i.(*helloRequestMsg)
+	return ok
+}
+
+func (*helloRequestMsg) marshal() []byte {
+	return []byte{typeHelloRequest, 0, 0, 0}
+}
+
+func (*helloRequestMsg) unmarshal(data []byte) bool {
+	return len(data) == 4
+}
+
+type newSessionTicketMsg struct {
+	raw    []byte
+	ticket []byte
+}
+
+func (m *newSessionTicketMsg) equal(i interface{}) bool {
+	m1, ok := i.(*newSessionTicketMsg)
+	if !ok {
+		return false
+	}
+
+	return bytes.Equal(m.ticket, m1.ticket)
+}
+
+func (m *newSessionTicketMsg) marshal() (x []byte) {
+	if m.raw != nil {
+		return m.raw
+	}
+
+	// See http://tools.ietf.org/html/rfc5077#section-3.3
+	ticketLen := len(m.ticket)
+	length := 2 + 4 + ticketLen
+	x = make([]byte, 4+length)
+	x[0] = typeNewSessionTicket
+	x[1] = uint8(length >> 16)
+	x[2] = uint8(length >> 8)
+	x[3] = uint8(length)
+	x[8] = uint8(ticketLen >> 8)
+	x[9] = uint8(ticketLen)
+	copy(x[10:], m.ticket)
+
+	m.raw = x
+
+	return
+}
+
+func (m *newSessionTicketMsg) unmarshal(data []byte) bool {
+	m.raw = data
+
+	if len(data) < 10 {
+		return false
+	}
+
+	ticketLen := int(data[8])<<8 + int(data[9])
+	if len(data)-10 != ticketLen {
+		return false
+	}
+
+	m.ticket = data[10:]
+
+	return true
+}
+
+// sessionState contains the information that is serialized into a session
+// ticket in order to recreate a session.
+type sessionState struct {
+	vers         uint16
+	cipherSuite  uint16
+	masterSecret []byte
+	certificates [][]byte
+}
+
+func (m *sessionState) equal(i interface{}) bool {
+	m1, ok := i.(*sessionState)
+	if !ok {
+		return false
+	}
+
+	return m.vers == m1.vers &&
+		m.cipherSuite == m1.cipherSuite &&
+		bytes.Equal(m.masterSecret, m1.masterSecret) &&
+		eqByteSlices(m.certificates, m1.certificates)
+}
+
+func (m *sessionState) marshal() []byte {
+	length := 2 + 2 + 2 + len(m.masterSecret) + 2
+	for _, cert := range m.certificates {
+		length += 3 + len(cert)
+	}
+
+	x := make([]byte, length)
+	x[0] = uint8(m.vers >> 8)
+	x[1] = uint8(m.vers)
+	x[2] = uint8(m.cipherSuite >> 8)
+	x[3] = uint8(m.cipherSuite)
+	x[4] = uint8(len(m.masterSecret) >> 8)
+	x[5] = uint8(len(m.masterSecret))
+	y := x[6:]
+	copy(y, m.masterSecret)
+	y = y[len(m.masterSecret):]
+
+	y[0] = uint8(len(m.certificates) >> 8)
+	y[1] = uint8(len(m.certificates))
+	z := y[2:]
+	for _, cert := range m.certificates {
+		z[0] = uint8(len(cert) >> 16)
+		z[1] = uint8(len(cert) >> 8)
+		z[2] = uint8(len(cert))
+		copy(z[3:], cert)
+		z = z[3+len(cert):]
+	}
+
+	return x
+}
+
+func (m *sessionState) unmarshal(data []byte) bool {
+	if len(data) < 8 {
+		return false
+	}
+	m.vers = uint16(data[0])<<8 | uint16(data[1])
+	m.cipherSuite = uint16(data[2])<<8 | uint16(data[3])
+	masterSecretLen := int(data[4])<<8 | int(data[5])
+	if masterSecretLen < 0 || masterSecretLen > len(data)-8 {
+		return false
+	}
+	m.masterSecret = data[6 : 6+masterSecretLen]
+	data = data[6+masterSecretLen:]
+
+	if len(data) < 2 {
+		return false
+	}
+	numCerts := int(data[0])<<8 | int(data[1])
+	data = data[2:]
+
+	m.certificates = make([][]byte, numCerts)
+	for i := 0; i < numCerts; i++ {
+		if len(data) < 3 {
+			return false
+		}
+		certLen := int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+		if len(data) < 3+certLen {
+			return false
+		}
+		m.certificates[i] = data[3 : 3+certLen]
+		data = data[3+certLen:]
+	}
+
+	return len(data) == 0
+}
+
+func eqUint16s(x, y []uint16) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i, v := range x {
+		if y[i] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func eqCurveIDs(x, y []CurveID) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i, v := range x {
+		if y[i] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func eqStrings(x, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i, v := range x {
+		if y[i] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func eqByteSlices(x, y [][]byte) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i, v := range x {
+		if !bytes.Equal(v, y[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func eqSignatureAndHashes(x, y []signatureAndHash) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i, v := range x {
+		v2 := y[i]
+		if v.hash != v2.hash || v.signature != v2.signature {
+			return false
+		}
+	}
+	return true
+}
+
+func eqKeyShares(x, y []keyShare) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i, v := range x {
+		v2 := y[i]
+		if v.group != v2.group || !bytes.Equal(v.data, v2.data) {
+			return false
+		}
+	}
+	return true
+}
+
+func eqPSKIdentities(x, y []pskIdentity) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i, v := range x {
+		v2 := y[i]
+		if v.obfuscatedTicketAge != v2.obfuscatedTicketAge || !bytes.Equal(v.label, v2.label) {
+			return false
+		}
+	}
+	return true
+}
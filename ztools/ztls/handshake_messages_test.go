@@ -25,9 +25,11 @@ var tests = []interface{}{
 	&newSessionTicketMsg{},
 	&sessionState{},
 	&serverHelloMsg13{},
+	&helloRetryRequestMsg{},
 	&helloRequestMsg{},
 	&encryptedExtensionsMsg{},
 	&certificateMsg13{},
+	&endOfEarlyDataMsg{},
 }
 
 type testMessage interface {
@@ -133,6 +135,12 @@ func (*clientHelloMsg) Generate(rand *rand.Rand, size int) reflect.Value {
 	for i := range m.supportedCurves {
 		m.supportedCurves[i] = CurveID(rand.Intn(30000))
 	}
+	if rand.Intn(10) > 5 {
+		m.supportedCurves = append(m.supportedCurves, X25519Kyber768Draft00)
+	}
+	if rand.Intn(10) > 5 {
+		m.supportedCurves = append(m.supportedCurves, X25519MLKEM768)
+	}
 	if rand.Intn(10) > 5 {
 		m.ticketSupported = true
 		if rand.Intn(10) > 5 {
@@ -148,13 +156,39 @@ func (*clientHelloMsg) Generate(rand *rand.Rand, size int) reflect.Value {
 	}
 	m.keyShares = make([]keyShare, rand.Intn(4))
 	for i := range m.keyShares {
-		m.keyShares[i].group = CurveID(rand.Intn(30000))
-		m.keyShares[i].data = randomBytes(rand.Intn(300), rand)
+		if len(m.supportedCurves) > 0 {
+			// Keep key_share groups consistent with supported_groups, since
+			// unmarshal rejects the mismatch when GREASE is disabled.
+			m.keyShares[i].group = m.supportedCurves[rand.Intn(len(m.supportedCurves))]
+		} else {
+			m.keyShares[i].group = CurveID(rand.Intn(30000))
+		}
+		// Hybrid post-quantum groups carry much larger key shares than the
+		// classical groups above, so give them a correspondingly larger cap.
+		dataCap := 300
+		if m.keyShares[i].group == X25519Kyber768Draft00 || m.keyShares[i].group == X25519MLKEM768 {
+			dataCap = 1216
+		}
+		m.keyShares[i].data = randomBytes(rand.Intn(dataCap)+1, rand)
 	}
 	m.supportedVersions = make([]uint16, rand.Intn(5))
 	for i := range m.supportedVersions {
 		m.supportedVersions[i] = uint16(rand.Intn(30000))
 	}
+	if rand.Intn(10) > 5 {
+		m.pskKeyExchangeModes = randomBytes(rand.Intn(3)+1, rand)
+		numIdentities := rand.Intn(3) + 1
+		m.pskIdentities = make([]pskIdentity, numIdentities)
+		m.pskBinders = make([][]byte, numIdentities)
+		for i := range m.pskIdentities {
+			m.pskIdentities[i].label = randomBytes(rand.Intn(32)+1, rand)
+			m.pskIdentities[i].obfuscatedTicketAge = rand.Uint32()
+			m.pskBinders[i] = randomBytes(rand.Intn(32)+32, rand)
+		}
+	}
+	if rand.Intn(10) > 5 {
+		m.grease = true
+	}
 
 	return reflect.ValueOf(m)
 }
@@ -194,8 +228,38 @@ func (*serverHelloMsg13) Generate(rand *rand.Rand, size int) reflect.Value {
 	m.random = randomBytes(32, rand)
 	m.cipherSuite = uint16(rand.Int31())
 	m.keyShare.group = CurveID(rand.Intn(30000))
-	m.keyShare.data = randomBytes(rand.Intn(300), rand)
+	if rand.Intn(10) > 5 {
+		if rand.Intn(2) == 0 {
+			m.keyShare.group = X25519Kyber768Draft00
+		} else {
+			m.keyShare.group = X25519MLKEM768
+		}
+	}
+	// Hybrid post-quantum groups carry much larger key shares than the
+	// classical groups above, so give them a correspondingly larger cap.
+	dataCap := 300
+	if m.keyShare.group == X25519Kyber768Draft00 || m.keyShare.group == X25519MLKEM768 {
+		dataCap = 1120
+	}
+	m.keyShare.data = randomBytes(rand.Intn(dataCap)+1, rand)
 	m.signatureAlgorithms = true
+	if rand.Intn(10) > 5 {
+		m.pskSelected = true
+		m.selectedIdentity = uint16(rand.Intn(65536))
+	}
+
+	return reflect.ValueOf(m)
+}
+
+func (*helloRetryRequestMsg) Generate(rand *rand.Rand, size int) reflect.Value {
+	m := &helloRetryRequestMsg{}
+	m.vers = uint16(rand.Intn(65536))
+	m.sessionId = randomBytes(rand.Intn(32), rand)
+	m.cipherSuite = uint16(rand.Int31())
+	m.selectedGroup = CurveID(rand.Intn(30000))
+	if rand.Intn(10) > 5 {
+		m.cookie = randomBytes(rand.Intn(64)+1, rand)
+	}
 
 	return reflect.ValueOf(m)
 }
@@ -0,0 +1,68 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+// recordType is the content type of a TLSPlaintext record, as defined in
+// RFC 8446, section 5.1.
+type recordType uint8
+
+const recordTypeHandshake recordType = 22
+
+// maxPlaintextRecordLength is the maximum number of fragment bytes carried
+// by a single TLSPlaintext record (RFC 8446, section 5.1). Handshake
+// messages larger than this must be split across multiple records.
+const maxPlaintextRecordLength = 1 << 14 // 16384
+
+// fragmentHandshake splits a marshaled handshake message into a sequence of
+// TLSPlaintext records (5-byte header followed by up to
+// maxPlaintextRecordLength fragment bytes), as required once the message no
+// longer fits in a single record.
+func fragmentHandshake(vers uint16, data []byte) [][]byte {
+	var records [][]byte
+	for {
+		n := len(data)
+		if n > maxPlaintextRecordLength {
+			n = maxPlaintextRecordLength
+		}
+		fragment := data[:n]
+		data = data[n:]
+
+		record := make([]byte, 5+len(fragment))
+		record[0] = uint8(recordTypeHandshake)
+		record[1] = uint8(vers >> 8)
+		record[2] = uint8(vers)
+		record[3] = uint8(len(fragment) >> 8)
+		record[4] = uint8(len(fragment))
+		copy(record[5:], fragment)
+		records = append(records, record)
+
+		if len(data) == 0 {
+			return records
+		}
+	}
+}
+
+// reassembleHandshake concatenates the fragments of a sequence of
+// TLSPlaintext handshake records back into the original handshake message,
+// verifying that each record's header is well-formed and of the expected
+// type.
+func reassembleHandshake(records [][]byte) ([]byte, bool) {
+	var out []byte
+	for _, record := range records {
+		if len(record) < 5 {
+			return nil, false
+		}
+		if recordType(record[0]) != recordTypeHandshake {
+			return nil, false
+		}
+		length := int(record[3])<<8 | int(record[4])
+		fragment := record[5:]
+		if len(fragment) != length {
+			return nil, false
+		}
+		out = append(out, fragment...)
+	}
+	return out, true
+}
@@ -0,0 +1,65 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// TestFragmentAndReassembleOversizedClientHello verifies that a ClientHello
+// carrying a hybrid post-quantum key share, padded past one TLSPlaintext
+// record by a large session ticket, is correctly split into multiple
+// records and reassembled before being handed back to
+// clientHelloMsg.unmarshal.
+func TestFragmentAndReassembleOversizedClientHello(t *testing.T) {
+	randSource := rand.New(rand.NewSource(1))
+
+	m := &clientHelloMsg{
+		vers:               0x0304,
+		random:             randomBytes(32, randSource),
+		sessionId:          randomBytes(32, randSource),
+		cipherSuites:       []uint16{0x1301, 0x1302},
+		compressionMethods: []uint8{0},
+		supportedCurves:    []CurveID{X25519Kyber768Draft00},
+		keyShares: []keyShare{
+			{group: X25519Kyber768Draft00, data: randomBytes(1216, randSource)},
+		},
+		ticketSupported: true,
+		sessionTicket:   randomBytes(20000, randSource),
+	}
+
+	marshaled := m.marshal()
+	if len(marshaled) <= maxPlaintextRecordLength {
+		t.Fatalf("test fixture too small to exercise fragmentation: %d bytes", len(marshaled))
+	}
+
+	records := fragmentHandshake(m.vers, marshaled)
+	if len(records) < 2 {
+		t.Fatalf("expected oversized ClientHello to span multiple records, got %d", len(records))
+	}
+	for _, record := range records[:len(records)-1] {
+		if len(record)-5 != maxPlaintextRecordLength {
+			t.Errorf("non-final record has a short fragment: %d bytes", len(record)-5)
+		}
+	}
+
+	reassembled, ok := reassembleHandshake(records)
+	if !ok {
+		t.Fatal("failed to reassemble fragmented handshake")
+	}
+	if !bytes.Equal(reassembled, marshaled) {
+		t.Fatal("reassembled handshake does not match the original marshaled bytes")
+	}
+
+	m2 := &clientHelloMsg{}
+	if !m2.unmarshal(reassembled) {
+		t.Fatal("failed to unmarshal reassembled ClientHello")
+	}
+	if !m.equal(m2) {
+		t.Fatal("reassembled ClientHello does not round-trip")
+	}
+}